@@ -0,0 +1,179 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Result describes the outcome of a report that was submitted via Input.AddReportAsync (or via
+// AddReportSync, which waits on that Result to give a synchronous call).
+type Result struct {
+	// OK is true if the report was durably persisted by every RetryingSender downstream of the
+	// Input it was added to.
+	OK bool
+
+	// Err is the first error encountered, either during immediate validation or during delivery
+	// to any one endpoint. It is nil if OK is true.
+	Err error
+
+	// EndpointResults holds the delivery error, if any, for every endpoint the report was
+	// dispatched to, keyed by endpoint identifier. An endpoint with a nil error succeeded.
+	EndpointResults map[string]error
+}
+
+// nextSequenceID produces the monotonic ids used to tag reports so that completion callbacks can
+// be matched back to the report that triggered them.
+var nextSequenceID uint64
+
+// NextSequenceID returns a process-wide monotonically increasing id suitable for tagging a
+// report's pending callback.
+func NextSequenceID() uint64 {
+	return atomic.AddUint64(&nextSequenceID, 1)
+}
+
+// CallbackPool runs AddReportAsync completion callbacks with bounded concurrency, so that a slow
+// or panicking caller-supplied callback can't block the RetryingSender chain that invokes it.
+// Callbacks are recovered from panics and abandoned if they run longer than Timeout.
+//
+// Dispatch itself never blocks: concurrency is capped by a limiter that each callback's own
+// goroutine waits on, not by a fixed-size queue that Dispatch could fill up. A queue sized to the
+// worker count would let a burst of slow callbacks re-introduce exactly the backpressure this
+// pool exists to remove.
+type CallbackPool struct {
+	// Timeout bounds how long a single callback may run before it is abandoned and logged. A
+	// Timeout of 0 means no bound.
+	Timeout time.Duration
+
+	limit chan struct{}
+}
+
+// NewCallbackPool returns a CallbackPool that runs at most workers callbacks concurrently.
+// workers must be at least 1.
+func NewCallbackPool(workers int, timeout time.Duration) *CallbackPool {
+	if workers < 1 {
+		workers = 1
+	}
+	return &CallbackPool{
+		Timeout: timeout,
+		limit:   make(chan struct{}, workers),
+	}
+}
+
+func (p *CallbackPool) runOne(fn func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("pipeline: recovered panic in AddReportAsync callback: %v", r)
+			}
+		}()
+		fn()
+	}()
+
+	if p.Timeout <= 0 {
+		<-done
+		return
+	}
+
+	select {
+	case <-done:
+	case <-time.After(p.Timeout):
+		log.Printf("pipeline: AddReportAsync callback did not return within %s; abandoning it", p.Timeout)
+	}
+}
+
+// Dispatch runs cb with result on a dedicated goroutine, bounded only by the pool's concurrency
+// limit. Dispatch itself never blocks the caller; any waiting for a free slot happens inside the
+// spawned goroutine, not on Dispatch's call stack.
+func (p *CallbackPool) Dispatch(cb func(Result), result Result) {
+	if cb == nil {
+		return
+	}
+	go func() {
+		p.limit <- struct{}{}
+		defer func() { <-p.limit }()
+		p.runOne(func() { cb(result) })
+	}()
+}
+
+// PendingCallbacks tracks in-flight AddReportAsync callbacks, keyed by the sequence id a
+// RetryingSender chain tags onto a report, and fires each one exactly once via a CallbackPool.
+type PendingCallbacks struct {
+	pool *CallbackPool
+
+	mu      sync.Mutex
+	pending map[uint64]func(Result)
+}
+
+// NewPendingCallbacks returns a PendingCallbacks that dispatches completions through pool.
+func NewPendingCallbacks(pool *CallbackPool) *PendingCallbacks {
+	return &PendingCallbacks{pool: pool, pending: make(map[uint64]func(Result))}
+}
+
+// Add registers cb under id. It must be called before the tagged report can possibly complete.
+func (p *PendingCallbacks) Add(id uint64, cb func(Result)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pending[id] = cb
+}
+
+// Complete fires the callback registered for id with result, then forgets it. Completing an
+// unknown or already-completed id is a no-op, since a multi-endpoint dispatch fans ackChan in
+// from more than one RetryingSender and only the last one to report in should complete the
+// callback; callers should use EndpointResults bookkeeping (not Complete itself) to decide which
+// call is last.
+func (p *PendingCallbacks) Complete(id uint64, result Result) {
+	p.mu.Lock()
+	cb, ok := p.pending[id]
+	if ok {
+		delete(p.pending, id)
+	}
+	p.mu.Unlock()
+
+	if ok {
+		p.pool.Dispatch(cb, result)
+	}
+}
+
+// AddReportSync adapts an AddReportAsync-shaped function to the synchronous AddReportCtx/AddReport
+// contract: it calls addAsync with a callback that delivers its Result over a channel, then blocks
+// on ctx or that channel, whichever comes first. Input implementations should use AddReportSync to
+// implement AddReportCtx and AddReport in terms of AddReportAsync, so the three APIs can't drift.
+func AddReportSync(ctx context.Context, addAsync func(context.Context, func(Result)) error) error {
+	results := make(chan Result, 1)
+	if err := addAsync(ctx, func(r Result) { results <- r }); err != nil {
+		return err
+	}
+
+	select {
+	case r := <-results:
+		if r.Err != nil {
+			return r.Err
+		}
+		if !r.OK {
+			return fmt.Errorf("pipeline: report was not delivered to all endpoints: %+v", r.EndpointResults)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
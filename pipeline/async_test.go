@@ -0,0 +1,162 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestCallbackPoolDispatchDoesNotBlock is a regression test for the bug fixed in this series:
+// Dispatch used to enqueue onto a channel sized to the worker count, so once every worker was
+// occupied by a slow callback, a further Dispatch blocked the caller. Dispatch must always return
+// immediately, regardless of how many callbacks are already running.
+func TestCallbackPoolDispatchDoesNotBlock(t *testing.T) {
+	pool := NewCallbackPool(1, 0)
+
+	block := make(chan struct{})
+	pool.Dispatch(func(Result) { <-block }, Result{})
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			pool.Dispatch(func(Result) {}, Result{})
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Dispatch blocked while the pool's single worker slot was occupied")
+	}
+
+	close(block)
+}
+
+// TestCallbackPoolRecoversPanic verifies that a callback panicking doesn't take down the pool or
+// prevent later callbacks from running.
+func TestCallbackPoolRecoversPanic(t *testing.T) {
+	pool := NewCallbackPool(1, 0)
+
+	pool.Dispatch(func(Result) { panic("boom") }, Result{})
+
+	next := make(chan struct{})
+	pool.Dispatch(func(Result) { close(next) }, Result{})
+
+	select {
+	case <-next:
+	case <-time.After(time.Second):
+		t.Fatal("callback pool did not recover from a panic in a previous callback")
+	}
+}
+
+// TestCallbackPoolAbandonsSlowCallbackAfterTimeout verifies that a callback exceeding Timeout is
+// abandoned -- the pool's concurrency slot is reclaimed even though the slow callback is still
+// running in the background -- rather than the pool staying wedged forever.
+func TestCallbackPoolAbandonsSlowCallbackAfterTimeout(t *testing.T) {
+	pool := NewCallbackPool(1, 20*time.Millisecond)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	pool.Dispatch(func(Result) {
+		close(started)
+		<-release // Block well past the pool's timeout.
+	}, Result{})
+	<-started
+
+	second := make(chan struct{})
+	pool.Dispatch(func(Result) { close(second) }, Result{})
+
+	select {
+	case <-second:
+	case <-time.After(time.Second):
+		t.Fatal("pool did not reclaim its slot after the first callback exceeded its timeout")
+	}
+
+	close(release)
+}
+
+// TestPendingCallbacksCompleteFiresOnce verifies that Complete fires the registered callback with
+// the given result, and that a second Complete for the same id is a no-op rather than firing
+// again.
+func TestPendingCallbacksCompleteFiresOnce(t *testing.T) {
+	pool := NewCallbackPool(1, 0)
+	pc := NewPendingCallbacks(pool)
+
+	var calls int32
+	done := make(chan Result, 1)
+	pc.Add(1, func(r Result) {
+		atomic.AddInt32(&calls, 1)
+		done <- r
+	})
+
+	pc.Complete(1, Result{OK: true})
+	pc.Complete(1, Result{OK: false}) // Second completion of the same id must be a no-op.
+
+	select {
+	case r := <-done:
+		if !r.OK {
+			t.Errorf("callback result: got %+v, want OK", r)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("callback never fired")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("callback fired %d times, want exactly 1", got)
+	}
+}
+
+// TestPendingCallbacksCompleteUnknownIDIsNoop verifies that completing an id that was never
+// registered (or was already completed) neither panics nor blocks.
+func TestPendingCallbacksCompleteUnknownIDIsNoop(t *testing.T) {
+	pool := NewCallbackPool(1, 0)
+	pc := NewPendingCallbacks(pool)
+
+	pc.Complete(999, Result{OK: true})
+}
+
+// TestAddReportSync verifies that AddReportSync blocks until the async callback fires and
+// translates its Result into a plain error, and that it returns ctx's error if ctx is done first.
+func TestAddReportSync(t *testing.T) {
+	ok := func(ctx context.Context, cb func(Result)) error {
+		cb(Result{OK: true})
+		return nil
+	}
+	if err := AddReportSync(context.Background(), ok); err != nil {
+		t.Errorf("AddReportSync with a successful result: unexpected error: %v", err)
+	}
+
+	wantErr := errors.New("endpoint unreachable")
+	failing := func(ctx context.Context, cb func(Result)) error {
+		cb(Result{Err: wantErr})
+		return nil
+	}
+	if err := AddReportSync(context.Background(), failing); err != wantErr {
+		t.Errorf("AddReportSync with a failing result: got %v, want %v", err, wantErr)
+	}
+
+	never := func(ctx context.Context, cb func(Result)) error { return nil }
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := AddReportSync(ctx, never); err != context.Canceled {
+		t.Errorf("AddReportSync with a cancelled ctx: got %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"context"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+)
+
+// BoundedInput wraps an Input with a PipelineSemaphore, so that the wrapped Input's in-flight
+// report count can't grow without bound: each report acquires a token before being handed to the
+// wrapped Input and releases it once the wrapped Input is done with it, per AckToken's contract.
+//
+// For the synchronous AddReportCtx/AddReport, "done with it" means the wrapped call has returned.
+// For AddReportAsync, the token is held until the completion callback fires, since that's the
+// point at which the report has been durably persisted by every RetryingSender and the wrapped
+// Input's state for it can be reclaimed.
+type BoundedInput struct {
+	Input
+
+	sem  *PipelineSemaphore
+	wait time.Duration
+}
+
+// NewBoundedInput returns a BoundedInput wrapping next. Acquiring a token blocks for up to wait
+// (0 means forever) or until the caller's ctx is done, whichever comes first, before returning
+// ErrPipelineFull or ctx.Err().
+func NewBoundedInput(next Input, sem *PipelineSemaphore, wait time.Duration) *BoundedInput {
+	return &BoundedInput{Input: next, sem: sem, wait: wait}
+}
+
+func (b *BoundedInput) AddReportCtx(ctx context.Context, report metrics.MetricReport) error {
+	tok, err := b.sem.Acquire(ctx, b.wait)
+	if err != nil {
+		return err
+	}
+	defer tok.Release()
+	return b.Input.AddReportCtx(ctx, report)
+}
+
+func (b *BoundedInput) AddReport(report metrics.MetricReport) error {
+	return b.AddReportCtx(context.Background(), report)
+}
+
+func (b *BoundedInput) AddReportAsync(ctx context.Context, report metrics.MetricReport, cb func(Result)) error {
+	tok, err := b.sem.Acquire(ctx, b.wait)
+	if err != nil {
+		return err
+	}
+
+	err = b.Input.AddReportAsync(ctx, report, func(r Result) {
+		tok.Release()
+		if cb != nil {
+			cb(r)
+		}
+	})
+	if err != nil {
+		tok.Release()
+	}
+	return err
+}
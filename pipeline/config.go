@@ -0,0 +1,36 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import "time"
+
+// PipelineConfig holds the tunables that govern a pipeline's runtime behavior, as opposed to its
+// topology (which aggregators feed which endpoints, etc). It is populated from the top-level
+// agent YAML config.
+type PipelineConfig struct {
+	// MaxInFlight is the maximum number of reports that may be outstanding between a Selector and
+	// its downstream RetryingSenders at any time. 0 means unlimited, which is the default and
+	// matches the pipeline's historical behavior.
+	MaxInFlight int `yaml:"maxInFlight"`
+
+	// MaxEnqueueWait is how long AddReport blocks waiting for a free slot once MaxInFlight is
+	// reached before returning ErrPipelineFull. 0 means block forever.
+	MaxEnqueueWait time.Duration `yaml:"maxEnqueueWait"`
+}
+
+// Semaphore builds the PipelineSemaphore described by this config.
+func (c PipelineConfig) Semaphore() *PipelineSemaphore {
+	return NewPipelineSemaphore(c.MaxInFlight)
+}
@@ -0,0 +1,76 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pipelinemetrics instruments the pipeline packages for external observability. It lives
+// under pipeline/metrics and is unrelated to github.com/GoogleCloudPlatform/ubbagent/metrics,
+// which describes the shape of a usage report; this package describes how the pipeline itself is
+// behaving as it moves those reports around. It is named distinctly from its import path so that
+// a file needing both packages -- any Observer-instrumented Input inevitably does, since it
+// records per-metrics.MetricReport events -- can import both without an alias.
+package pipelinemetrics
+
+import "time"
+
+// Observer receives events from every Component in a pipeline as reports flow through it. Each
+// method corresponds to one or more of the metrics a Prometheus-backed Observer exports; a
+// no-op Observer is used when no observability backend is configured, so instrumentation calls
+// stay cheap on the hot path.
+type Observer interface {
+	// RecordReceived is called when an Input accepts a report for a metric.
+	RecordReceived(metric string)
+
+	// RecordRejected is called when an Input rejects a report immediately, such as for an
+	// unknown metric. reason is a short, low-cardinality label such as "unknown_metric" or
+	// "invalid_value".
+	RecordRejected(metric, reason string)
+
+	// RecordBucketOpened is called when an Aggregator opens a new aggregation bucket for metric.
+	RecordBucketOpened(metric string)
+
+	// RecordFlush is called when an Aggregator flushes a bucket for metric downstream, with the
+	// serialized size in bytes of the flushed report.
+	RecordFlush(metric string, bytes int)
+
+	// RecordDispatch is called when a Dispatcher hands a report to its downstream RetryingSenders.
+	RecordDispatch(metric string)
+
+	// RecordQueueDepth is called by a RetryingSender whenever its persisted send queue depth
+	// changes, so depth is the new absolute value, not a delta.
+	RecordQueueDepth(endpoint string, depth int)
+
+	// RecordRetryAttempt is called each time a RetryingSender retries a send to endpoint.
+	RecordRetryAttempt(endpoint string)
+
+	// RecordSendDuration is called after a RetryingSender attempts a send to endpoint. result is
+	// a short, low-cardinality label such as "success" or "error".
+	RecordSendDuration(endpoint, result string, d time.Duration)
+
+	// RecordSuccess is called after a RetryingSender successfully sends to endpoint, with the
+	// time of that success, so operators can alert on a stale endpoint_last_success_timestamp.
+	RecordSuccess(endpoint string, at time.Time)
+}
+
+// NoopObserver implements Observer by discarding every event. It is the default Observer used
+// when no metrics backend is configured.
+type NoopObserver struct{}
+
+func (NoopObserver) RecordReceived(metric string)                                {}
+func (NoopObserver) RecordRejected(metric, reason string)                        {}
+func (NoopObserver) RecordBucketOpened(metric string)                            {}
+func (NoopObserver) RecordFlush(metric string, bytes int)                        {}
+func (NoopObserver) RecordDispatch(metric string)                                {}
+func (NoopObserver) RecordQueueDepth(endpoint string, depth int)                 {}
+func (NoopObserver) RecordRetryAttempt(endpoint string)                          {}
+func (NoopObserver) RecordSendDuration(endpoint, result string, d time.Duration) {}
+func (NoopObserver) RecordSuccess(endpoint string, at time.Time)                 {}
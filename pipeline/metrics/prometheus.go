@@ -0,0 +1,188 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipelinemetrics
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Config configures the Prometheus HTTP listener that exposes a PrometheusObserver's metrics.
+type Config struct {
+	// Listen is the address the /metrics HTTP server binds to, e.g. ":9090". An empty Listen
+	// disables the server.
+	Listen string `yaml:"listen"`
+
+	// Path is the HTTP path the metrics are served on. Defaults to "/metrics".
+	Path string `yaml:"path"`
+}
+
+// label cardinality guardrails: endpoint and metric names come from the agent's own YAML config,
+// not from request payloads, so their cardinality is bounded by the number of configured
+// endpoints/metrics. reason and result are fixed, small enumerations defined by this package's
+// callers -- never pass a caller-controlled string as a label.
+const (
+	labelReason   = "reason"
+	labelEndpoint = "endpoint"
+	labelResult   = "result"
+)
+
+// PrometheusObserver is an Observer backed by Prometheus counters, gauges, and histograms,
+// registered on a caller-owned prometheus.Registry so the agent can expose them alongside any
+// other metrics it collects about itself.
+type PrometheusObserver struct {
+	reportsReceived prometheus.Counter
+	reportsRejected *prometheus.CounterVec
+	bucketsOpen     prometheus.Gauge
+	flushBytes      prometheus.Histogram
+	dispatched      prometheus.Counter
+	queueDepth      *prometheus.GaugeVec
+	retryAttempts   *prometheus.CounterVec
+	sendDuration    *prometheus.HistogramVec
+	lastSuccess     *prometheus.GaugeVec
+}
+
+// NewPrometheusObserver registers the pipeline's metrics on reg and returns an Observer that
+// records to them. Metric names and help text are fixed so that dashboards built against one
+// ubbagent deployment work against any other.
+func NewPrometheusObserver(reg *prometheus.Registry) *PrometheusObserver {
+	o := &PrometheusObserver{
+		reportsReceived: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "reports_received_total",
+			Help: "Total number of reports accepted by a pipeline Input.",
+		}),
+		reportsRejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "reports_rejected_total",
+			Help: "Total number of reports rejected immediately by a pipeline Input, by reason.",
+		}, []string{labelReason}),
+		bucketsOpen: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "aggregator_buckets_open",
+			Help: "Number of aggregation buckets currently open awaiting flush.",
+		}),
+		flushBytes: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "aggregator_flush_bytes",
+			Help:    "Serialized size, in bytes, of reports flushed downstream by an Aggregator.",
+			Buckets: prometheus.ExponentialBuckets(64, 4, 8),
+		}),
+		dispatched: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "dispatcher_dispatched_total",
+			Help: "Total number of reports handed off by a Dispatcher to its RetryingSenders.",
+		}),
+		queueDepth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "retryingsender_queue_depth",
+			Help: "Current number of reports persisted and awaiting send, by endpoint.",
+		}, []string{labelEndpoint}),
+		retryAttempts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "retryingsender_retry_attempts_total",
+			Help: "Total number of send retries, by endpoint.",
+		}, []string{labelEndpoint}),
+		sendDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "retryingsender_send_duration_seconds",
+			Help: "Duration of send attempts to an endpoint, by endpoint and result.",
+		}, []string{labelEndpoint, labelResult}),
+		lastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "endpoint_last_success_timestamp_seconds",
+			Help: "Unix timestamp of the last successful send to an endpoint.",
+		}, []string{labelEndpoint}),
+	}
+
+	reg.MustRegister(
+		o.reportsReceived,
+		o.reportsRejected,
+		o.bucketsOpen,
+		o.flushBytes,
+		o.dispatched,
+		o.queueDepth,
+		o.retryAttempts,
+		o.sendDuration,
+		o.lastSuccess,
+	)
+	return o
+}
+
+func (o *PrometheusObserver) RecordReceived(metric string) {
+	o.reportsReceived.Inc()
+}
+
+func (o *PrometheusObserver) RecordRejected(metric, reason string) {
+	o.reportsRejected.WithLabelValues(reason).Inc()
+}
+
+func (o *PrometheusObserver) RecordBucketOpened(metric string) {
+	o.bucketsOpen.Inc()
+}
+
+func (o *PrometheusObserver) RecordFlush(metric string, bytes int) {
+	o.bucketsOpen.Dec()
+	o.flushBytes.Observe(float64(bytes))
+}
+
+func (o *PrometheusObserver) RecordDispatch(metric string) {
+	o.dispatched.Inc()
+}
+
+func (o *PrometheusObserver) RecordQueueDepth(endpoint string, depth int) {
+	o.queueDepth.WithLabelValues(endpoint).Set(float64(depth))
+}
+
+func (o *PrometheusObserver) RecordRetryAttempt(endpoint string) {
+	o.retryAttempts.WithLabelValues(endpoint).Inc()
+}
+
+func (o *PrometheusObserver) RecordSendDuration(endpoint, result string, d time.Duration) {
+	o.sendDuration.WithLabelValues(endpoint, result).Observe(d.Seconds())
+}
+
+func (o *PrometheusObserver) RecordSuccess(endpoint string, at time.Time) {
+	o.lastSuccess.WithLabelValues(endpoint).Set(float64(at.Unix()))
+}
+
+// Serve starts an HTTP server exposing reg on cfg.Listen/cfg.Path and blocks until ctx is
+// cancelled, at which point it shuts the server down gracefully. An empty cfg.Listen disables the
+// server: Serve returns nil immediately without binding anything. Serve is a package-level
+// function taking cfg and reg as well as ctx, so it does not itself implement pipeline.Server.
+func Serve(ctx context.Context, cfg Config, reg *prometheus.Registry) error {
+	if cfg.Listen == "" {
+		return nil
+	}
+
+	path := cfg.Path
+	if path == "" {
+		path = "/metrics"
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(path, promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	srv := &http.Server{Addr: cfg.Listen, Handler: mux}
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- srv.ListenAndServe() }()
+
+	select {
+	case err := <-errCh:
+		if err == http.ErrServerClosed {
+			return nil
+		}
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return srv.Shutdown(shutdownCtx)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipelinemetrics
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// drive pushes a handful of synthetic events through obs, simulating reports moving through a
+// pipeline from Input to a slow-ish endpoint.
+func drive(obs *PrometheusObserver) {
+	obs.RecordReceived("requests")
+	obs.RecordBucketOpened("requests")
+	obs.RecordFlush("requests", 128)
+	obs.RecordDispatch("requests")
+	obs.RecordQueueDepth("bigquery", 3)
+	obs.RecordRetryAttempt("bigquery")
+	obs.RecordSendDuration("bigquery", "success", 15*time.Millisecond)
+	obs.RecordSuccess("bigquery", time.Unix(1700000000, 0))
+	obs.RecordRejected("requests", "unknown_metric")
+}
+
+func TestPrometheusObserverScrape(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	obs := NewPrometheusObserver(reg)
+	drive(obs)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	promhttp.HandlerFor(reg, promhttp.HandlerOpts{}).ServeHTTP(rec, req)
+
+	if rec.Code != 200 {
+		t.Fatalf("scrape returned status %d", rec.Code)
+	}
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		"reports_received_total 1",
+		`reports_rejected_total{reason="unknown_metric"} 1`,
+		"aggregator_flush_bytes_sum 128",
+		"dispatcher_dispatched_total 1",
+		`retryingsender_queue_depth{endpoint="bigquery"} 3`,
+		`retryingsender_retry_attempts_total{endpoint="bigquery"} 1`,
+		`endpoint_last_success_timestamp_seconds{endpoint="bigquery"} 1.7e+09`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("scrape output missing %q\ngot:\n%s", want, body)
+		}
+	}
+}
+
+func TestServeSkipsEmptyListen(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- Serve(ctx, Config{}, reg) }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Serve with empty Listen returned error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Serve with empty Listen did not return immediately; it likely tried to bind a real listener")
+	}
+}
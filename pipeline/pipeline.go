@@ -27,6 +27,7 @@
 package pipeline
 
 import (
+	"context"
 	"sync"
 
 	"github.com/GoogleCloudPlatform/ubbagent/metrics"
@@ -38,9 +39,28 @@ type Input interface {
 	// Input is also a Component.
 	Component
 
-	// AddReport adds a report to the pipeline. It returns an error if one is known immediately,
+	// AddReportCtx adds a report to the pipeline. It returns an error if one is known immediately,
 	// such as a report that refers to unknown metrics. See aggregator.Aggregator.
+	//
+	// If the Input is backed by a PipelineSemaphore with a MaxInFlight limit, AddReportCtx blocks
+	// until a slot frees up, and returns ErrPipelineFull if none does within MaxEnqueueWait.
+	//
+	// ctx is used both for cancellation and, when a tracer is configured, as the parent of the
+	// span tree tracking this report as it flows through the pipeline.
+	AddReportCtx(ctx context.Context, report metrics.MetricReport) error
+
+	// AddReport is equivalent to AddReportCtx with context.Background().
+	//
+	// Deprecated: use AddReportCtx so that tracing and cancellation propagate through the
+	// pipeline.
 	AddReport(metrics.MetricReport) error
+
+	// AddReportAsync adds a report to the pipeline without blocking on delivery. It still
+	// returns an immediate error for validation failures that AddReportCtx would reject outright,
+	// such as a report referring to unknown metrics; in that case cb is never called. Otherwise,
+	// cb fires exactly once, on a dedicated callback worker goroutine, once the report has been
+	// durably persisted by every RetryingSender downstream of this Input.
+	AddReportAsync(ctx context.Context, report metrics.MetricReport, cb func(Result)) error
 }
 
 // Component represents a single component in a pipeline. Components can be used downstream of
@@ -113,7 +133,9 @@ func (u *UsageTracker) Release(close func() error) error {
 }
 
 // ReleaseAll calls Release on all of the given Components in parallel, returning a multierror if
-// one or more calls fail, or nil if all succeed.
+// one or more calls fail, or nil if all succeed. ComponentGroup calls ReleaseAll from its
+// GracefulStop, so a Runner with a registered ComponentGroup releases those Components during its
+// stop phase.
 func ReleaseAll(components []Component) error {
 	errors := make([]error, len(components))
 	wg := sync.WaitGroup{}
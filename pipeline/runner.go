@@ -0,0 +1,234 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"context"
+	"log"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// LifecycleComponent is registered with a Runner. It has no required methods of its own -- a
+// component opts into each phase of the run by implementing PreRunner, Server, and/or
+// GracefulStopper. Runner skips every phase for a component that implements none of these; such a
+// component is counted in the stop-phase log line but is never named in Runner's logs.
+type LifecycleComponent interface{}
+
+// PreRunner components perform one-time setup before any component is served, such as verifying
+// that a persistence directory is writable. PreRun errors abort startup: the Runner fails fast
+// rather than calling Serve on anything.
+type PreRunner interface {
+	PreRun() error
+}
+
+// Server components run until ctx is cancelled. Serve should block until the component has
+// finished serving; a typical implementation selects on ctx.Done() and returns nil when it fires.
+type Server interface {
+	Serve(ctx context.Context) error
+}
+
+// GracefulStopper components wind down in response to GracefulStop, returning once they have
+// stopped sending data to downstream components. GracefulStop is called with a context that is
+// cancelled when the Runner's shutdown deadline is exceeded.
+type GracefulStopper interface {
+	GracefulStop(ctx context.Context) error
+}
+
+// ComponentGroup adapts a slice of Components to GracefulStopper by calling ReleaseAll on them
+// when stopped. Register a ComponentGroup with a Runner to have the stop phase drive a set of
+// Inputs' Release chains exactly as ReleaseAll did before Runner existed; ReleaseAll itself
+// remains unaware of Runner and can still be called directly.
+type ComponentGroup []Component
+
+// GracefulStop releases every Component in the group. ctx is unused: Component.Release has no
+// context parameter, so a group that doesn't finish before the Runner's shutdown deadline is
+// simply reported as outstanding by Run, not cancelled.
+func (g ComponentGroup) GracefulStop(ctx context.Context) error {
+	return ReleaseAll([]Component(g))
+}
+
+// SourceAdapter adapts a Source to GracefulStopper so it can be registered with a Runner
+// alongside Inputs and other Components, unifying Source shutdown under the Runner's
+// deterministic stop phase instead of being invoked through a separate, bespoke path.
+type SourceAdapter struct {
+	Source
+}
+
+// GracefulStop shuts down the wrapped Source. ctx is unused: Source.Shutdown has no context
+// parameter, so a Source that doesn't finish before the Runner's shutdown deadline is simply
+// reported as outstanding by Run, not cancelled.
+func (a SourceAdapter) GracefulStop(ctx context.Context) error {
+	return a.Source.Shutdown()
+}
+
+// registration pairs a LifecycleComponent with the name it was registered under, for logging.
+type registration struct {
+	name string
+	c    LifecycleComponent
+}
+
+// serveResult carries a Server's outcome back to Run, tagged with the name it was registered
+// under so an unexpected exit can be logged clearly.
+type serveResult struct {
+	name string
+	err  error
+}
+
+// Runner coordinates the startup and shutdown of a pipeline's Sources, Inputs, and Components.
+// It replaces ad-hoc combinations of Component.Use/Release and Source.Shutdown with a single,
+// ordered, observable lifecycle: components are registered in dependency order, PreRun hooks run
+// first and fail fast, Serve hooks run for the duration of the pipeline, and GracefulStop hooks
+// run in reverse registration order when the Runner is asked to stop.
+//
+// Runner does not replace Component's Use/Release reference counting; components still use
+// UsageTracker underneath so that fork/join topologies release exactly once. Runner simply
+// decides when the top-level Release happens: register the pipeline's top-level Inputs as a
+// ComponentGroup and Runner's GracefulStop phase calls ReleaseAll on them at the right point in
+// the stop order, in place of a bespoke shutdown path.
+type Runner struct {
+	// ShutdownDeadline bounds how long GracefulStop hooks, and Servers that have not yet returned,
+	// are given to finish once shutdown begins. If it elapses, Run logs which GracefulStop hooks
+	// are still outstanding and how many Servers are, and returns.
+	ShutdownDeadline time.Duration
+
+	regs []registration
+}
+
+// NewRunner returns a Runner whose GracefulStop phase is bounded by shutdownDeadline. A
+// shutdownDeadline of 0 means wait forever.
+func NewRunner(shutdownDeadline time.Duration) *Runner {
+	return &Runner{ShutdownDeadline: shutdownDeadline}
+}
+
+// Register adds c, identified by name, to the end of the Runner's dependency order. PreRun and
+// Serve hooks run in registration order; GracefulStop hooks run in the reverse order, so that
+// components are stopped before the components they depend on.
+func (r *Runner) Register(name string, c LifecycleComponent) {
+	r.regs = append(r.regs, registration{name, c})
+}
+
+// Run starts every registered component and blocks until ctx is cancelled, a SIGINT/SIGTERM is
+// received, a registered Server exits with an error, or (when at least one Server is registered)
+// every registered Server has returned -- whichever happens first -- then stops every component
+// in reverse order. A Server that exits with an error before shutdown was
+// otherwise requested triggers shutdown immediately rather than going unnoticed until some later
+// signal. Run returns a multierror collecting every error encountered across the PreRun, Serve,
+// and GracefulStop phases, or nil if none occurred.
+func (r *Runner) Run(ctx context.Context) error {
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var errs *multierror.Error
+
+	for _, reg := range r.regs {
+		if p, ok := reg.c.(PreRunner); ok {
+			log.Printf("pipeline: %s: pre-run", reg.name)
+			if err := p.PreRun(); err != nil {
+				log.Printf("pipeline: %s: pre-run failed: %v", reg.name, err)
+				errs = multierror.Append(errs, err)
+				return errs.ErrorOrNil()
+			}
+		}
+	}
+
+	serveErrs := make(chan serveResult, len(r.regs))
+	var serving int
+	for _, reg := range r.regs {
+		if s, ok := reg.c.(Server); ok {
+			serving++
+			go func(reg registration, s Server) {
+				log.Printf("pipeline: %s: serving", reg.name)
+				err := s.Serve(ctx)
+				if err != nil {
+					log.Printf("pipeline: %s: serve returned error: %v", reg.name, err)
+				} else {
+					log.Printf("pipeline: %s: serve returned", reg.name)
+				}
+				serveErrs <- serveResult{reg.name, err}
+			}(reg, s)
+		}
+	}
+
+	// Wait for shutdown to be requested via ctx, or for a Server to exit on its own first. Either
+	// way we want Run to notice immediately rather than only checking once ctx is done: a Server
+	// that exits with an error before shutdown was requested (e.g. a listener that fails to bind)
+	// should trigger shutdown and be reported, not be silently ignored until some unrelated signal
+	// arrives.
+	remaining := serving
+waitForShutdown:
+	for {
+		select {
+		case <-ctx.Done():
+			break waitForShutdown
+		case res := <-serveErrs:
+			remaining--
+			if res.err != nil {
+				log.Printf("pipeline: %s exited before shutdown was requested; stopping the pipeline", res.name)
+				errs = multierror.Append(errs, res.err)
+				stop()
+			} else if remaining == 0 {
+				// Every Server has returned on its own with no error; there's nothing left to
+				// wait on, so proceed to the stop phase for everything else.
+				stop()
+			}
+		}
+	}
+	log.Printf("pipeline: shutdown requested, stopping %d component(s)", len(r.regs))
+
+	stopCtx := context.Background()
+	var cancel context.CancelFunc
+	if r.ShutdownDeadline > 0 {
+		stopCtx, cancel = context.WithTimeout(stopCtx, r.ShutdownDeadline)
+		defer cancel()
+	}
+
+	for i := len(r.regs) - 1; i >= 0; i-- {
+		reg := r.regs[i]
+		if gs, ok := reg.c.(GracefulStopper); ok {
+			log.Printf("pipeline: %s: graceful-stop", reg.name)
+			done := make(chan error, 1)
+			go func() { done <- gs.GracefulStop(stopCtx) }()
+			select {
+			case err := <-done:
+				if err != nil {
+					errs = multierror.Append(errs, err)
+				}
+			case <-stopCtx.Done():
+				log.Printf("pipeline: %s: did not finish stopping before the shutdown deadline", reg.name)
+				errs = multierror.Append(errs, stopCtx.Err())
+			}
+		}
+	}
+
+waitForServers:
+	for i := 0; i < remaining; i++ {
+		select {
+		case res := <-serveErrs:
+			if res.err != nil {
+				errs = multierror.Append(errs, res.err)
+			}
+		case <-stopCtx.Done():
+			log.Printf("pipeline: %d server(s) did not return before the shutdown deadline", remaining-i)
+			errs = multierror.Append(errs, stopCtx.Err())
+			break waitForServers
+		}
+	}
+
+	return errs.ErrorOrNil()
+}
@@ -0,0 +1,157 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// failingServer is a Server whose Serve returns err immediately, simulating a listener that
+// can't bind.
+type failingServer struct {
+	err error
+}
+
+func (f failingServer) Serve(ctx context.Context) error {
+	return f.err
+}
+
+// TestRunnerNoticesEarlyServeError verifies that Run returns promptly -- rather than hanging
+// until ctx is separately cancelled -- when a registered Server exits with an error before
+// shutdown was otherwise requested.
+func TestRunnerNoticesEarlyServeError(t *testing.T) {
+	wantErr := errors.New("listen tcp :9090: address already in use")
+	r := NewRunner(time.Second)
+	r.Register("failing", failingServer{err: wantErr})
+
+	done := make(chan error, 1)
+	go func() { done <- r.Run(context.Background()) }()
+
+	select {
+	case err := <-done:
+		if err == nil || !errors.Is(err, wantErr) {
+			t.Errorf("Run returned %v, want an error wrapping %v", err, wantErr)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after a registered Server exited with an error")
+	}
+}
+
+// blockingServer blocks until ctx is cancelled, like a typical long-running Server.
+type blockingServer struct{}
+
+func (blockingServer) Serve(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
+
+// recordingStopper records whether GracefulStop was called.
+type recordingStopper struct {
+	stopped chan struct{}
+}
+
+func (s *recordingStopper) GracefulStop(ctx context.Context) error {
+	close(s.stopped)
+	return nil
+}
+
+// TestRunnerGracefulStopsOnContextCancel verifies the ordinary shutdown path still works: Run
+// runs GracefulStop hooks once the caller cancels ctx.
+func TestRunnerGracefulStopsOnContextCancel(t *testing.T) {
+	stopper := &recordingStopper{stopped: make(chan struct{})}
+	r := NewRunner(time.Second)
+	r.Register("server", blockingServer{})
+	r.Register("stopper", stopper)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- r.Run(ctx) }()
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Run returned unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after ctx was cancelled")
+	}
+
+	select {
+	case <-stopper.stopped:
+	default:
+		t.Error("GracefulStop was never called")
+	}
+}
+
+// TestSourceAdapterCallsShutdown verifies SourceAdapter's GracefulStop delegates to the wrapped
+// Source's Shutdown.
+func TestSourceAdapterCallsShutdown(t *testing.T) {
+	src := &fakeSource{}
+	adapter := SourceAdapter{Source: src}
+
+	if err := adapter.GracefulStop(context.Background()); err != nil {
+		t.Fatalf("GracefulStop: unexpected error: %v", err)
+	}
+	if !src.shutdown {
+		t.Error("SourceAdapter.GracefulStop did not call Shutdown on the wrapped Source")
+	}
+}
+
+type fakeSource struct {
+	shutdown bool
+}
+
+func (s *fakeSource) Shutdown() error {
+	s.shutdown = true
+	return nil
+}
+
+// fakeComponent is a Component that records whether Release was called.
+type fakeComponent struct {
+	UsageTracker
+	released bool
+}
+
+func (c *fakeComponent) Use() {
+	c.UsageTracker.Use()
+}
+
+func (c *fakeComponent) Release() error {
+	return c.UsageTracker.Release(func() error {
+		c.released = true
+		return nil
+	})
+}
+
+// TestComponentGroupGracefulStopReleasesAll verifies ComponentGroup's GracefulStop calls Release
+// on every Component in the group.
+func TestComponentGroupGracefulStopReleasesAll(t *testing.T) {
+	a := &fakeComponent{}
+	b := &fakeComponent{}
+	group := ComponentGroup{a, b}
+
+	if err := group.GracefulStop(context.Background()); err != nil {
+		t.Fatalf("GracefulStop: unexpected error: %v", err)
+	}
+	if !a.released || !b.released {
+		t.Errorf("not all components were released: a=%v b=%v", a.released, b.released)
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrPipelineFull is returned by PipelineSemaphore.Acquire (and, in turn, by Input.AddReport) when
+// the configured number of in-flight reports is reached and MaxEnqueueWait elapses before a slot
+// frees up.
+var ErrPipelineFull = errors.New("pipeline: too many reports in flight")
+
+// AckToken is held by a report as it moves through the pipeline and released exactly once, when
+// the report is no longer the pipeline's responsibility: either it has been folded into an
+// aggregation bucket that has already been handed to a downstream Dispatcher, or it has been
+// persisted by every RetryingSender in its fan-out. Components that hand a report off to another
+// Component without immediately releasing its token must propagate the token alongside the
+// report so the hand-off preserves exactly-once release.
+type AckToken interface {
+	// Release returns the token's slot to the PipelineSemaphore it was acquired from. Release is
+	// idempotent: calling it more than once has no effect beyond the first call.
+	Release()
+}
+
+// PipelineSemaphore bounds the number of reports that may be outstanding between a Selector and
+// the downstream RetryingSenders it feeds, so that a bursty source or a stalled endpoint can't
+// grow the pipeline's in-memory or on-disk state without limit. It is modeled on the
+// producer-consumer state-limit used by chain tracers: a fixed pool of tokens is handed out by
+// Acquire and returned by AckToken.Release.
+//
+// A PipelineSemaphore is shared by all Inputs feeding a given Dispatcher, so the limit applies to
+// the aggregate in-flight count across every producer, not to each Input individually.
+type PipelineSemaphore struct {
+	tokens chan struct{}
+}
+
+// NewPipelineSemaphore returns a PipelineSemaphore that admits at most maxInFlight reports at a
+// time. A maxInFlight of 0 or less means unlimited, preserving the pipeline's historical
+// behavior; Acquire always succeeds immediately and Release is a no-op.
+func NewPipelineSemaphore(maxInFlight int) *PipelineSemaphore {
+	if maxInFlight <= 0 {
+		return &PipelineSemaphore{}
+	}
+	return &PipelineSemaphore{tokens: make(chan struct{}, maxInFlight)}
+}
+
+// Acquire blocks until a slot is available, wait elapses, ctx is done, or the semaphore is
+// unlimited. It returns ErrPipelineFull if wait elapses first, or ctx's error if ctx is done
+// first. A wait of 0 means block forever, bounded only by ctx.
+func (s *PipelineSemaphore) Acquire(ctx context.Context, wait time.Duration) (AckToken, error) {
+	if s.tokens == nil {
+		return noopAckToken{}, nil
+	}
+
+	if wait <= 0 {
+		select {
+		case s.tokens <- struct{}{}:
+			return s.newToken(), nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case s.tokens <- struct{}{}:
+		return s.newToken(), nil
+	case <-timer.C:
+		return nil, ErrPipelineFull
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *PipelineSemaphore) newToken() AckToken {
+	return &semaphoreToken{tokens: s.tokens}
+}
+
+type semaphoreToken struct {
+	tokens   chan struct{}
+	released bool
+	mu       sync.Mutex
+}
+
+func (t *semaphoreToken) Release() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.released {
+		return
+	}
+	t.released = true
+	<-t.tokens
+}
+
+// noopAckToken is returned by an unlimited PipelineSemaphore; releasing it does nothing.
+type noopAckToken struct{}
+
+func (noopAckToken) Release() {}
@@ -0,0 +1,170 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+)
+
+func TestPipelineSemaphoreAcquireRelease(t *testing.T) {
+	ctx := context.Background()
+	sem := NewPipelineSemaphore(1)
+
+	tok, err := sem.Acquire(ctx, 0)
+	if err != nil {
+		t.Fatalf("Acquire: unexpected error: %v", err)
+	}
+
+	if _, err := sem.Acquire(ctx, 10*time.Millisecond); err != ErrPipelineFull {
+		t.Fatalf("Acquire on a full semaphore: got %v, want ErrPipelineFull", err)
+	}
+
+	tok.Release()
+	tok.Release() // Release must be idempotent.
+
+	if _, err := sem.Acquire(ctx, 10*time.Millisecond); err != nil {
+		t.Fatalf("Acquire after Release: unexpected error: %v", err)
+	}
+}
+
+func TestPipelineSemaphoreUnlimited(t *testing.T) {
+	ctx := context.Background()
+	sem := NewPipelineSemaphore(0)
+	for i := 0; i < 100; i++ {
+		if _, err := sem.Acquire(ctx, 0); err != nil {
+			t.Fatalf("Acquire %d on an unlimited semaphore: unexpected error: %v", i, err)
+		}
+	}
+}
+
+// TestPipelineSemaphoreAcquireRespectsContext verifies that Acquire returns promptly when the
+// caller's ctx is cancelled, rather than waiting out the full MaxEnqueueWait (or blocking forever,
+// when wait is 0).
+func TestPipelineSemaphoreAcquireRespectsContext(t *testing.T) {
+	sem := NewPipelineSemaphore(1)
+	if _, err := sem.Acquire(context.Background(), 0); err != nil {
+		t.Fatalf("first Acquire: unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+
+	start := time.Now()
+	_, err := sem.Acquire(ctx, time.Hour)
+	if err != context.Canceled {
+		t.Fatalf("Acquire with a cancelled ctx: got %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Acquire took %s to notice ctx cancellation", elapsed)
+	}
+}
+
+// slowInput is a fake Input that simulates a slow endpoint: each report takes delay to "deliver."
+type slowInput struct {
+	delay time.Duration
+}
+
+func (s *slowInput) Use() {}
+
+func (s *slowInput) Release() error {
+	return nil
+}
+
+func (s *slowInput) AddReportCtx(ctx context.Context, report metrics.MetricReport) error {
+	time.Sleep(s.delay)
+	return nil
+}
+
+func (s *slowInput) AddReport(report metrics.MetricReport) error {
+	return s.AddReportCtx(context.Background(), report)
+}
+
+func (s *slowInput) AddReportAsync(ctx context.Context, report metrics.MetricReport, cb func(Result)) error {
+	go func() {
+		time.Sleep(s.delay)
+		if cb != nil {
+			cb(Result{OK: true})
+		}
+	}()
+	return nil
+}
+
+// TestBoundedInputBlocksThenRejects drives reports through a BoundedInput backed by a slow
+// endpoint and a single in-flight slot. It verifies that AddReport blocks while the slot is held
+// and returns ErrPipelineFull, rather than growing memory unbounded, once MaxEnqueueWait elapses.
+func TestBoundedInputBlocksThenRejects(t *testing.T) {
+	bounded := NewBoundedInput(&slowInput{delay: 100 * time.Millisecond}, NewPipelineSemaphore(1), 20*time.Millisecond)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := bounded.AddReport(metrics.MetricReport{}); err != nil {
+			t.Errorf("first AddReport: unexpected error: %v", err)
+		}
+	}()
+
+	// Give the first AddReport time to acquire the only slot before we try a second one.
+	time.Sleep(20 * time.Millisecond)
+
+	if err := bounded.AddReport(metrics.MetricReport{}); err != ErrPipelineFull {
+		t.Errorf("second AddReport while the slot is held: got %v, want ErrPipelineFull", err)
+	}
+
+	wg.Wait()
+
+	// The slot was released when the first report finished, so a subsequent report should
+	// succeed again rather than staying rejected forever.
+	if err := bounded.AddReport(metrics.MetricReport{}); err != nil {
+		t.Errorf("AddReport after the slot freed up: unexpected error: %v", err)
+	}
+}
+
+// TestBoundedInputAsyncHoldsTokenUntilCallback verifies that AddReportAsync keeps its token held
+// until the completion callback fires, not merely until the call returns -- otherwise a bursty
+// caller could race past MaxInFlight before any report is actually durable.
+func TestBoundedInputAsyncHoldsTokenUntilCallback(t *testing.T) {
+	bounded := NewBoundedInput(&slowInput{delay: 50 * time.Millisecond}, NewPipelineSemaphore(1), 10*time.Millisecond)
+
+	done := make(chan Result, 1)
+	if err := bounded.AddReportAsync(context.Background(), metrics.MetricReport{}, func(r Result) { done <- r }); err != nil {
+		t.Fatalf("AddReportAsync: unexpected error: %v", err)
+	}
+
+	if err := bounded.AddReport(metrics.MetricReport{}); err != ErrPipelineFull {
+		t.Errorf("AddReport while the async slot is still outstanding: got %v, want ErrPipelineFull", err)
+	}
+
+	select {
+	case r := <-done:
+		if !r.OK {
+			t.Errorf("callback result: got %+v, want OK", r)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("AddReportAsync callback never fired")
+	}
+
+	if err := bounded.AddReport(metrics.MetricReport{}); err != nil {
+		t.Errorf("AddReport after the async callback fired: unexpected error: %v", err)
+	}
+}
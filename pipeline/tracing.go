@@ -0,0 +1,69 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName identifies this package as the instrumentation source in exported spans.
+const instrumentationName = "github.com/GoogleCloudPlatform/ubbagent/pipeline"
+
+// Span names used across the pipeline packages, so that a trace backend groups spans from every
+// Component under consistent names regardless of which endpoint or aggregator produced them.
+const (
+	SpanAggregatorFlush    = "pipeline.aggregator.flush"
+	SpanDispatcherDispatch = "pipeline.dispatcher.dispatch"
+	SpanRetryingSenderSend = "pipeline.retryingsender.send"
+)
+
+// EndpointSpanName returns the span name a RetryingSender/Endpoint pair should use for a send to
+// the named endpoint, e.g. "pipeline.endpoint.bigquery.send".
+func EndpointSpanName(endpoint string) string {
+	return "pipeline.endpoint." + endpoint + ".send"
+}
+
+// Attribute keys applied to the spans above. Components should prefer these constants over
+// ad-hoc strings so that dashboards built against one exporter keep working as Components are
+// added.
+const (
+	AttributeMetricName   = attribute.Key("pipeline.metric_name")
+	AttributeBatchSize    = attribute.Key("pipeline.batch_size")
+	AttributeRetryAttempt = attribute.Key("pipeline.retry_attempt")
+	AttributeEndpoint     = attribute.Key("pipeline.endpoint")
+)
+
+// Tracer returns a trace.Tracer for this package's instrumentation, using tp if non-nil or a
+// no-op TracerProvider otherwise. Components should call Tracer once at construction time and
+// hold onto the result, rather than calling it on every AddReportCtx, so that the hot path with
+// tracing disabled stays allocation-free.
+func Tracer(tp trace.TracerProvider) trace.Tracer {
+	if tp == nil {
+		tp = trace.NewNoopTracerProvider()
+	}
+	return tp.Tracer(instrumentationName)
+}
+
+// LinkAll returns a trace.Link for every span context in contributing, for use when starting a
+// follow-up span -- such as an aggregator flush -- caused by more than one prior span, such as
+// the reports folded into the flushed bucket.
+func LinkAll(contributing []trace.SpanContext) []trace.Link {
+	links := make([]trace.Link, len(contributing))
+	for i, sc := range contributing {
+		links[i] = trace.Link{SpanContext: sc}
+	}
+	return links
+}
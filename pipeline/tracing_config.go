@@ -0,0 +1,100 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exporter selects which OpenTelemetry trace exporter a TracingConfig builds.
+type Exporter string
+
+const (
+	// ExporterNone disables tracing; NewTracerProvider returns a no-op provider. This is the
+	// zero value, so a TracingConfig left unset preserves the pipeline's historical behavior.
+	ExporterNone Exporter = ""
+
+	// ExporterOTLPGRPC exports spans over OTLP/gRPC to TracingConfig.Endpoint.
+	ExporterOTLPGRPC Exporter = "otlp-grpc"
+
+	// ExporterOTLPHTTP exports spans over OTLP/HTTP to TracingConfig.Endpoint.
+	ExporterOTLPHTTP Exporter = "otlp-http"
+
+	// ExporterStdout writes spans to stdout as JSON. Useful for local development.
+	ExporterStdout Exporter = "stdout"
+)
+
+// TracingConfig configures the OpenTelemetry tracer provider shared by every Component in a
+// pipeline. It is populated from the top-level agent YAML config's `tracing:` block.
+type TracingConfig struct {
+	// Exporter selects the trace backend. The zero value, ExporterNone, disables tracing.
+	Exporter Exporter `yaml:"exporter"`
+
+	// Endpoint is the collector address used by ExporterOTLPGRPC and ExporterOTLPHTTP, e.g.
+	// "localhost:4317". Ignored by other exporters.
+	Endpoint string `yaml:"endpoint"`
+}
+
+// NewTracerProvider builds the trace.TracerProvider described by cfg, along with a shutdown
+// function that flushes and releases the exporter's resources. The caller must call shutdown
+// when the pipeline stops, typically from a GracefulStopper registered with a Runner.
+//
+// An ExporterNone config (the zero value) returns trace.NewNoopTracerProvider() and a no-op
+// shutdown, so tracing stays entirely optional and the hot path stays allocation-free when it's
+// not configured.
+func NewTracerProvider(ctx context.Context, cfg TracingConfig) (trace.TracerProvider, func(context.Context) error, error) {
+	noop := func(context.Context) error { return nil }
+
+	if cfg.Exporter == ExporterNone {
+		return trace.NewNoopTracerProvider(), noop, nil
+	}
+
+	exp, err := newSpanExporter(ctx, cfg)
+	if err != nil {
+		return nil, noop, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless())
+	if err != nil {
+		return nil, noop, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res),
+	)
+	return tp, tp.Shutdown, nil
+}
+
+func newSpanExporter(ctx context.Context, cfg TracingConfig) (sdktrace.SpanExporter, error) {
+	switch cfg.Exporter {
+	case ExporterOTLPGRPC:
+		return otlptracegrpc.New(ctx, otlptracegrpc.WithEndpoint(cfg.Endpoint), otlptracegrpc.WithInsecure())
+	case ExporterOTLPHTTP:
+		return otlptracehttp.New(ctx, otlptracehttp.WithEndpoint(cfg.Endpoint), otlptracehttp.WithInsecure())
+	case ExporterStdout:
+		return stdouttrace.New()
+	default:
+		return nil, fmt.Errorf("pipeline: unknown tracing exporter %q", cfg.Exporter)
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import (
+	"context"
+
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SpanInputAddReport is the span an Input starts for each report it accepts, the root of the span
+// tree described in the pipeline package doc: Input -> Selector -> Aggregator -> Dispatcher ->
+// RetryingSender -> Endpoint.
+const SpanInputAddReport = "pipeline.input.add_report"
+
+// TracingInput wraps an Input, starting a SpanInputAddReport span for every report and recording
+// on it whatever error the wrapped Input ultimately produces. Downstream Components that accept a
+// trace.TracerProvider at construction and start their own spans (pipeline.aggregator.flush,
+// pipeline.dispatcher.dispatch, pipeline.retryingsender.send, pipeline.endpoint.<name>.send) make
+// this span their parent, via the ctx that AddReportCtx/AddReportAsync propagate.
+type TracingInput struct {
+	Input
+	tracer trace.Tracer
+}
+
+// NewTracingInput returns a TracingInput wrapping next, using tp to create spans. tp may be nil,
+// in which case spans are created but discarded by a no-op provider.
+func NewTracingInput(next Input, tp trace.TracerProvider) *TracingInput {
+	return &TracingInput{Input: next, tracer: Tracer(tp)}
+}
+
+func (t *TracingInput) AddReportCtx(ctx context.Context, report metrics.MetricReport) error {
+	ctx, span := t.tracer.Start(ctx, SpanInputAddReport,
+		trace.WithAttributes(AttributeMetricName.String(report.Name)))
+	defer span.End()
+
+	err := t.Input.AddReportCtx(ctx, report)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+func (t *TracingInput) AddReport(report metrics.MetricReport) error {
+	return t.AddReportCtx(context.Background(), report)
+}
+
+func (t *TracingInput) AddReportAsync(ctx context.Context, report metrics.MetricReport, cb func(Result)) error {
+	ctx, span := t.tracer.Start(ctx, SpanInputAddReport,
+		trace.WithAttributes(AttributeMetricName.String(report.Name)))
+
+	err := t.Input.AddReportAsync(ctx, report, func(r Result) {
+		if r.Err != nil {
+			span.RecordError(r.Err)
+			span.SetStatus(codes.Error, r.Err.Error())
+		}
+		span.End()
+		if cb != nil {
+			cb(r)
+		}
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
+	}
+	return err
+}